@@ -0,0 +1,369 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package golang
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// buildPrologueTemplate selects the build mode (vendor, module, or plain
+// GOPATH) once, storing the flags shared by every requested target
+// platform's build line in MOD_FLAGS. Each configured go command
+// environment variable (GOPROXY, GOSUMDB, ...) is exported on its own line
+// by moduleBuildEnv rather than folded into a single space-joined string,
+// so a multi-flag GOFLAGS value (e.g. "-mod=mod -trimpath") is never
+// word-split.
+const buildPrologueTemplate = `
+set -e
+if [ -f "/chaincode/input/src/go.mod" ] && [ -d "/chaincode/input/src/vendor" ]; then
+    cd /chaincode/input/src
+    MOD_FLAGS="-mod=vendor"
+elif [ -f "/chaincode/input/src/go.mod" ]; then
+    if [ "%s" = "off" ]; then
+        echo "chaincode.golang.goproxy=off requires a vendor directory" >&2
+        exit 1
+    fi
+    cd /chaincode/input/src
+    MOD_FLAGS="-mod=readonly"
+%s
+else
+    MOD_FLAGS=""
+    export GOPATH=/chaincode/input:$GOPATH
+fi
+mkdir -p /chaincode/output
+`
+
+// buildTargetTemplate cross-compiles and packages a single
+// chaincode.golang.platforms entry into binpackage-<os>-<arch>.tar.
+const buildTargetTemplate = `mkdir -p %[1]s
+GO111MODULE=on GOOS=%[2]s GOARCH=%[3]s CGO_ENABLED=0 go build -v $MOD_FLAGS %[4]s -o %[1]s/chaincode %[5]s
+tar -C %[1]s -cf %[1]s.tar chaincode
+`
+
+const dockerfileTemplate = "FROM {{.Runtime}}\nADD binpackage.tar /usr/local/bin"
+
+// buildDockerfileTemplate is the Dockerfile used to actually compile the
+// chaincode: a real Go toolchain image, wholly unrelated to the minimal
+// runtime image dockerfileTemplate assembles. It never references
+// binpackage.tar, which does not exist until build.sh has run.
+const buildDockerfileTemplate = "FROM {{.BuilderImage}}\nCOPY . /chaincode/input\nRUN [\"/bin/sh\", \"/chaincode/input/build.sh\"]\n"
+
+// defaultBuilderImage is the Go toolchain image used to compile chaincode
+// when chaincode.golang.builderImage is not configured.
+const defaultBuilderImage = "golang:1.21"
+
+// builderImage returns the Go toolchain image to build chaincode in,
+// honoring chaincode.golang.builderImage.
+func builderImage() string {
+	if image := viper.GetString("chaincode.golang.builderImage"); image != "" {
+		return image
+	}
+	return defaultBuilderImage
+}
+
+// TargetPlatform is a single GOOS/GOARCH pair requested via
+// chaincode.golang.platforms. It is exported so that callers outside this
+// package (such as the chaincode launcher, which must pick the binary
+// matching the peer's own architecture out of a built package) can resolve
+// and reason about the platforms a package was built for.
+type TargetPlatform struct {
+	OS   string
+	Arch string
+}
+
+func (t TargetPlatform) String() string {
+	return t.OS + "/" + t.Arch
+}
+
+var platformPattern = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// TargetPlatforms parses chaincode.golang.platforms, a comma-separated list
+// of goos/goarch pairs such as "linux/amd64,linux/arm64,linux/s390x". When
+// unset, it defaults to a single target matching the build host so that
+// existing single-architecture deployments are unaffected.
+func TargetPlatforms() ([]TargetPlatform, error) {
+	raw := viper.GetString("chaincode.golang.platforms")
+	if raw == "" {
+		return []TargetPlatform{{OS: runtime.GOOS, Arch: runtime.GOARCH}}, nil
+	}
+
+	var targets []TargetPlatform
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		parts := strings.Split(entry, "/")
+		if len(parts) != 2 || !platformPattern.MatchString(parts[0]) || !platformPattern.MatchString(parts[1]) {
+			return nil, errors.Errorf("invalid chaincode.golang.platforms entry %q: expected goos/goarch", entry)
+		}
+		targets = append(targets, TargetPlatform{OS: parts[0], Arch: parts[1]})
+	}
+
+	return targets, nil
+}
+
+// runtimeImage returns the runtime base image for the given target
+// platform, honoring a per-arch override
+// (chaincode.golang.runtime.<os>-<arch>) before falling back to the
+// platform-wide chaincode.golang.runtime.
+func runtimeImage(target TargetPlatform) string {
+	key := fmt.Sprintf("chaincode.golang.runtime.%s-%s", target.OS, target.Arch)
+	if override := viper.GetString(key); override != "" {
+		return override
+	}
+	return viper.GetString("chaincode.golang.runtime")
+}
+
+// shellMetacharacters matches any character that would let a
+// chaincode.golang.go* viper value escape its position in the build script
+// rendered from buildPrologueTemplate.
+var shellMetacharacters = regexp.MustCompile("[;&|<>$`\\\"'(){}\n\r]")
+
+// goproxyMetacharacters is shellMetacharacters minus "|", which GOPROXY uses
+// to list fallback proxies (e.g. "https://proxy.internal|https://proxy.golang.org",
+// falling through on 404/410 per `go help goproxy`). GOPROXY only ever lands
+// inside a double-quoted `export GOPROXY="..."` line emitted by
+// moduleBuildEnv, where "|" has no shell meaning, so it is safe to allow
+// there alone.
+var goproxyMetacharacters = regexp.MustCompile("[;&<>$`\\\"'(){}\n\r]")
+
+// rejectHeredocTerminator returns an error if content contains a line that
+// is exactly "EOF", the terminator buildInstructions uses for every
+// `cat > ... <<'EOF'` block in the generated build script. Unlike the
+// chaincode.golang.go* values moduleBuildEnv validates, a runtime image
+// (chaincode.golang.runtime[.<os>-<arch>]) is not restricted to a safe
+// character set, and now lands inside one of those heredocs: a value that
+// happened to render an "EOF" line would end the heredoc early, so
+// everything meant to be part of it spills out and runs as shell input
+// instead.
+func rejectHeredocTerminator(content string) error {
+	for _, line := range strings.Split(content, "\n") {
+		if line == "EOF" {
+			return errors.New(`rendered content contains a line reading exactly "EOF", which would terminate the build script's heredoc early`)
+		}
+	}
+	return nil
+}
+
+// moduleBuildEnv resolves and validates the viper-configured go command
+// environment for module-mode builds: chaincode.golang.goproxy,
+// chaincode.golang.gosumdb, chaincode.golang.goprivate,
+// chaincode.golang.gonosumcheck and chaincode.golang.goflags. It lets
+// air-gapped and enterprise deployments point module-mode builds at an
+// internal proxy, disable the public checksum database for private module
+// paths, and pass through extra go build flags, without exposing the
+// generated build script to shell injection. Each variable is returned as
+// its own `export KEY="VALUE"` line rather than a single space-joined
+// string: GOFLAGS is documented as a space-separated list of flags, and
+// folding it into one string that a caller later word-splits treats each
+// flag after the first as a separate token instead of part of GOFLAGS.
+func moduleBuildEnv() (goproxy string, exports string, err error) {
+	goproxy = viper.GetString("chaincode.golang.goproxy")
+	if goproxy == "" {
+		goproxy = defaultGoProxy
+	}
+
+	vars := []struct {
+		key   string
+		value string
+	}{
+		{"GOPROXY", goproxy},
+		{"GOSUMDB", viper.GetString("chaincode.golang.gosumdb")},
+		{"GOPRIVATE", viper.GetString("chaincode.golang.goprivate")},
+		{"GONOSUMCHECK", viper.GetString("chaincode.golang.gonosumcheck")},
+		{"GOFLAGS", viper.GetString("chaincode.golang.goflags")},
+	}
+
+	lines := make([]string, 0, len(vars))
+	for _, v := range vars {
+		if v.value == "" {
+			continue
+		}
+		pattern := shellMetacharacters
+		if v.key == "GOPROXY" {
+			pattern = goproxyMetacharacters
+		}
+		if pattern.MatchString(v.value) {
+			return "", "", errors.Errorf("invalid character in chaincode.golang config value for %s: %q", v.key, v.value)
+		}
+		lines = append(lines, fmt.Sprintf("    export %s=%q", v.key, v.value))
+	}
+
+	return goproxy, strings.Join(lines, "\n"), nil
+}
+
+// BuildInstructions captures the backend-agnostic description of how to
+// turn the chaincode input tar into a binpackage.tar: Dockerfile is used to
+// assemble the runtime image once a binpackage.tar exists, BuildDockerfile
+// is the separate, Go-toolchain-equipped image that Builder implementations
+// actually compile the chaincode in by running Script.
+type BuildInstructions struct {
+	Dockerfile      string
+	BuildDockerfile string
+	Script          string
+}
+
+// BuildOptions carries everything a Builder needs to turn the chaincode
+// input tar rooted at /chaincode/input/src into the contents of
+// /chaincode/output: binpackage.tar, the per-arch binpackage-<os>-<arch>.tar
+// archives and manifest.json.
+type BuildOptions struct {
+	Path         string
+	Instructions BuildInstructions
+}
+
+// Builder produces a chaincode binpackage.tar from the chaincode input tar
+// without assuming the presence of a docker daemon.
+type Builder interface {
+	Build(ctx context.Context, inputTar io.Reader, outputTar io.Writer, opts BuildOptions) error
+}
+
+// NewBuilder returns the Builder selected by chaincode.golang.builder
+// ("docker", "kaniko" or "buildah"). It defaults to "docker" so that
+// existing deployments that rely on a docker daemon keep working
+// unchanged.
+func NewBuilder() (Builder, error) {
+	switch name := viper.GetString("chaincode.golang.builder"); name {
+	case "", "docker":
+		return &DockerBuilder{}, nil
+	case "kaniko":
+		return &KanikoBuilder{}, nil
+	case "buildah":
+		return &BuildahBuilder{}, nil
+	default:
+		return nil, errors.Errorf("unknown chaincode.golang.builder %q: must be one of docker, kaniko, buildah", name)
+	}
+}
+
+// Build compiles and packages the chaincode source in inputTar (as produced
+// by GetDeploymentPayload) using the backend selected by
+// chaincode.golang.builder, writing the resulting /chaincode/output
+// directory (binpackage.tar, manifest.json, and any per-arch archives) to
+// outputTar. This is the entry point for daemonless chaincode builds: a
+// peer that wants to avoid shelling out to a docker daemon calls Build
+// directly instead of driving GenerateDockerfile/DockerBuildOptions through
+// its own docker client.
+func (p *Platform) Build(ctx context.Context, path string, inputTar io.Reader, outputTar io.Writer) error {
+	instructions, err := p.buildInstructions(path)
+	if err != nil {
+		return err
+	}
+
+	builder, err := NewBuilder()
+	if err != nil {
+		return err
+	}
+
+	return builder.Build(ctx, inputTar, outputTar, BuildOptions{Path: path, Instructions: instructions})
+}
+
+// renderDockerfile renders dockerfileTemplate for the given runtime base
+// image.
+func renderDockerfile(image string) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	tmpl := template.Must(template.New("Dockerfile").Parse(dockerfileTemplate))
+	if err := tmpl.Execute(buf, map[string]string{"Runtime": image}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderBuildDockerfile renders buildDockerfileTemplate for the given Go
+// toolchain image.
+func renderBuildDockerfile(image string) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	tmpl := template.Must(template.New("BuildDockerfile").Parse(buildDockerfileTemplate))
+	if err := tmpl.Execute(buf, map[string]string{"BuilderImage": image}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// GenerateDockerfileForPlatform is like GenerateDockerfile but honors a
+// per-arch runtime image override (chaincode.golang.runtime.<os>-<arch>)
+// for the given target, falling back to chaincode.golang.runtime.
+func (p *Platform) GenerateDockerfileForPlatform(target TargetPlatform) (string, error) {
+	return renderDockerfile(runtimeImage(target))
+}
+
+// buildInstructions assembles the Dockerfile and build script shared by all
+// backends. path is the import/module path of the chaincode being built; it
+// is only required when generating the build script. The build script
+// cross-compiles one binpackage-<os>-<arch>.tar per chaincode.golang.platforms
+// entry and records the mapping in manifest.json. It also copies the first
+// target's archive to the canonical /chaincode/output/binpackage.tar, since
+// that is the file GenerateDockerfile's "ADD binpackage.tar" line expects
+// regardless of how many platforms were requested.
+//
+// BuildInstructions.Dockerfile, returned by GenerateDockerfile, is likewise
+// only ever the first target's runtime image. With more than one
+// chaincode.golang.platforms entry that isn't enough to run the other
+// archs, so the script also writes every target's own runtime Dockerfile
+// to /chaincode/output/Dockerfile-<os>-<arch>; building the matching
+// runtime image for a non-primary target, and picking among them at
+// chaincode start time, is left to whatever launcher drives this package -
+// no such launcher exists in this tree.
+func (p *Platform) buildInstructions(path string) (BuildInstructions, error) {
+	targets, err := TargetPlatforms()
+	if err != nil {
+		return BuildInstructions{}, err
+	}
+
+	goproxy, env, err := moduleBuildEnv()
+	if err != nil {
+		return BuildInstructions{}, err
+	}
+
+	ldflagsOpts := getLDFlagsOpts()
+
+	script := bytes.NewBuffer(nil)
+	fmt.Fprintf(script, buildPrologueTemplate, goproxy, env)
+
+	var dockerfile string
+	manifest := make([]string, 0, len(targets))
+	var primaryArchive string
+	for i, target := range targets {
+		outDir := fmt.Sprintf("/chaincode/output/binpackage-%s-%s", target.OS, target.Arch)
+		fmt.Fprintf(script, buildTargetTemplate, outDir, target.OS, target.Arch, ldflagsOpts, path)
+		archive := fmt.Sprintf("binpackage-%s-%s.tar", target.OS, target.Arch)
+		manifest = append(manifest, fmt.Sprintf(`  %q: %q`, target.String(), archive))
+		if primaryArchive == "" {
+			primaryArchive = outDir + ".tar"
+		}
+
+		targetDockerfile, err := p.GenerateDockerfileForPlatform(target)
+		if err != nil {
+			return BuildInstructions{}, err
+		}
+		if i == 0 {
+			dockerfile = targetDockerfile
+		}
+		if err := rejectHeredocTerminator(targetDockerfile); err != nil {
+			return BuildInstructions{}, errors.Wrapf(err, "runtime image for %s", target)
+		}
+		fmt.Fprintf(script, "cat > /chaincode/output/Dockerfile-%s-%s <<'EOF'\n%s\nEOF\n", target.OS, target.Arch, targetDockerfile)
+	}
+
+	fmt.Fprintf(script, "cat > /chaincode/output/manifest.json <<'EOF'\n{\n%s\n}\nEOF\n", strings.Join(manifest, ",\n"))
+	fmt.Fprintf(script, "cp %s /chaincode/output/binpackage.tar\n", primaryArchive)
+	script.WriteString("echo Done!\n")
+
+	buildDockerfile, err := renderBuildDockerfile(builderImage())
+	if err != nil {
+		return BuildInstructions{}, err
+	}
+
+	return BuildInstructions{Dockerfile: dockerfile, BuildDockerfile: buildDockerfile, Script: script.String()}, nil
+}
@@ -0,0 +1,58 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package golang
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/containers/buildah"
+	"github.com/containers/buildah/imagebuildah"
+	"github.com/pkg/errors"
+)
+
+// BuildahBuilder builds chaincode with Buildah's build package, giving
+// rootless/Kubernetes deployments a second daemonless option besides
+// Kaniko. It is selected with chaincode.golang.builder=buildah.
+type BuildahBuilder struct{}
+
+// Build writes inputTar to a scratch build context, runs Buildah's build
+// package against the build-only Dockerfile and script that actually
+// compile the chaincode, and streams just the resulting /chaincode/output
+// directory back out through outputTar.
+func (b *BuildahBuilder) Build(ctx context.Context, inputTar io.Reader, outputTar io.Writer, opts BuildOptions) error {
+	buildContext, err := ioutil.TempDir("", "buildah-build-context")
+	if err != nil {
+		return errors.Wrap(err, "failed to create buildah build context")
+	}
+	defer os.RemoveAll(buildContext)
+
+	if err := extractInputTar(inputTar, buildContext); err != nil {
+		return errors.Wrap(err, "failed to extract chaincode input tar")
+	}
+
+	dockerfilePath, err := writeDockerfile(buildContext, opts.Instructions.BuildDockerfile, opts.Instructions.Script)
+	if err != nil {
+		return err
+	}
+
+	store, err := buildah.GetStore(buildah.StoreOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to open buildah store")
+	}
+
+	imageID, _, _, err := imagebuildah.BuildDockerfiles(ctx, store, imagebuildah.BuildOptions{
+		ContextDirectory: buildContext,
+	}, dockerfilePath)
+	if err != nil {
+		return errors.Wrap(err, "buildah build failed")
+	}
+
+	return writeImageAsTar(store, imageID, outputTar)
+}
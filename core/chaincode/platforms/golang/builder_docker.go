@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package golang
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DockerBuilder builds chaincode by shelling out to a docker daemon. It is
+// the default backend and preserves the historical build path for
+// deployments where /var/run/docker.sock is available.
+type DockerBuilder struct{}
+
+// Build extracts inputTar into a scratch build context, builds the
+// BuildDockerfile/Script carried in opts.Instructions into a throwaway
+// image, then copies just that image's /chaincode/output directory into
+// outputTar via `docker cp`.
+func (b *DockerBuilder) Build(ctx context.Context, inputTar io.Reader, outputTar io.Writer, opts BuildOptions) error {
+	buildContext, err := ioutil.TempDir("", "docker-build-context")
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker build context")
+	}
+	defer os.RemoveAll(buildContext)
+
+	if err := extractInputTar(inputTar, buildContext); err != nil {
+		return errors.Wrap(err, "failed to extract chaincode input tar")
+	}
+
+	dockerfilePath, err := writeDockerfile(buildContext, opts.Instructions.BuildDockerfile, opts.Instructions.Script)
+	if err != nil {
+		return err
+	}
+
+	image := fmt.Sprintf("fabric-chaincode-build:%d", os.Getpid())
+	if err := dockerRun(ctx, "build", "--rm", "-q", "-f", dockerfilePath, "-t", image, buildContext); err != nil {
+		return errors.Wrap(err, "docker build failed")
+	}
+	defer exec.Command("docker", "rmi", "-f", image).Run()
+
+	containerID, err := dockerOutput(ctx, "create", image)
+	if err != nil {
+		return errors.Wrap(err, "docker create failed")
+	}
+	container := strings.TrimSpace(containerID)
+	defer exec.Command("docker", "rm", "-f", container).Run()
+
+	cmd := exec.CommandContext(ctx, "docker", "cp", container+":"+outputDir+"/.", "-")
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+	cmd.Stdout = outputTar
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "docker cp failed: %s", stderr.String())
+	}
+
+	return nil
+}
+
+// dockerRun runs a docker subcommand whose output is not needed, returning
+// stderr wrapped into the error on failure.
+func dockerRun(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return errors.New(stderr.String())
+	}
+	return nil
+}
+
+// dockerOutput runs a docker subcommand and returns its stdout.
+func dockerOutput(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.New(stderr.String())
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package golang
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/executor"
+	"github.com/pkg/errors"
+)
+
+// KanikoBuilder builds chaincode with Kaniko's in-process executor, so that
+// the peer never needs access to a docker daemon or /var/run/docker.sock.
+// It is selected with chaincode.golang.builder=kaniko.
+type KanikoBuilder struct{}
+
+// Build writes inputTar to a scratch build context, runs Kaniko's executor
+// against the build-only Dockerfile and script that actually compile the
+// chaincode, and streams just the resulting /chaincode/output directory
+// back out through outputTar.
+func (b *KanikoBuilder) Build(ctx context.Context, inputTar io.Reader, outputTar io.Writer, opts BuildOptions) error {
+	buildContext, err := ioutil.TempDir("", "kaniko-build-context")
+	if err != nil {
+		return errors.Wrap(err, "failed to create kaniko build context")
+	}
+	defer os.RemoveAll(buildContext)
+
+	if err := extractInputTar(inputTar, buildContext); err != nil {
+		return errors.Wrap(err, "failed to extract chaincode input tar")
+	}
+
+	dockerfilePath, err := writeDockerfile(buildContext, opts.Instructions.BuildDockerfile, opts.Instructions.Script)
+	if err != nil {
+		return err
+	}
+
+	opt := &config.KanikoOptions{
+		DockerfilePath: dockerfilePath,
+		SrcContext:     buildContext,
+		SnapshotMode:   "redo",
+		NoPush:         true,
+		Destinations:   []string{"fabric-chaincode/binpackage:latest"},
+	}
+
+	image, err := executor.DoBuild(opt)
+	if err != nil {
+		return errors.Wrap(err, "kaniko build failed")
+	}
+
+	return writeLayersAsTar(image, outputTar)
+}
@@ -0,0 +1,145 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package golang
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBuilder(t *testing.T) {
+	var tests = []struct {
+		name    string
+		builder string
+		typ     Builder
+		succ    bool
+	}{
+		{name: "Default", builder: "", typ: &DockerBuilder{}, succ: true},
+		{name: "Docker", builder: "docker", typ: &DockerBuilder{}, succ: true},
+		{name: "Kaniko", builder: "kaniko", typ: &KanikoBuilder{}, succ: true},
+		{name: "Buildah", builder: "buildah", typ: &BuildahBuilder{}, succ: true},
+		{name: "Unknown", builder: "bogus", succ: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("chaincode.golang.builder", tt.builder)
+			defer viper.Set("chaincode.golang.builder", "")
+
+			builder, err := NewBuilder()
+			if !tt.succ {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.IsType(t, tt.typ, builder)
+		})
+	}
+}
+
+// TestBuildBackends exercises every Builder against the noop chaincode
+// fixture used throughout this package's tests. Each backend depends on
+// tooling (a docker daemon, the kaniko executor's root privileges, a
+// buildah store) that is not guaranteed to be present in every
+// environment, so the test skips itself when that specific prerequisite is
+// missing. Any other error out of Build is a real failure and is asserted,
+// not skipped.
+func TestBuildBackends(t *testing.T) {
+	platform := &Platform{}
+
+	inputTar, err := platform.GetDeploymentPayload("github.com/hyperledger/fabric/core/chaincode/platforms/golang/testdata/src/chaincodes/noop")
+	require.NoError(t, err, "failed to build chaincode input tar from noop fixture")
+
+	instructions, err := platform.buildInstructions("github.com/hyperledger/fabric/core/chaincode/platforms/golang/testdata/src/chaincodes/noop")
+	require.NoError(t, err)
+
+	opts := BuildOptions{
+		Path:         "github.com/hyperledger/fabric/core/chaincode/platforms/golang/testdata/src/chaincodes/noop",
+		Instructions: instructions,
+	}
+
+	var tests = []struct {
+		name         string
+		builder      Builder
+		bin          string
+		requiresRoot bool
+	}{
+		{name: "Docker", builder: &DockerBuilder{}, bin: "docker"},
+		{name: "Kaniko", builder: &KanikoBuilder{}, requiresRoot: true},
+		{name: "Buildah", builder: &BuildahBuilder{}, bin: "buildah"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.bin != "" {
+				if _, err := exec.LookPath(tt.bin); err != nil {
+					t.Skipf("%s not available: %s", tt.bin, err)
+				}
+			}
+			if tt.requiresRoot && os.Geteuid() != 0 {
+				t.Skip("kaniko's executor requires root privileges")
+			}
+
+			outputTar := bytes.NewBuffer(nil)
+			err := tt.builder.Build(context.Background(), bytes.NewReader(inputTar), outputTar, opts)
+			require.NoError(t, err, "%s backend build failed", tt.name)
+			assert.NotEmpty(t, outputTar.Bytes())
+		})
+	}
+}
+
+// TestPlatformBuild covers Platform.Build, the entry point that actually
+// wires chaincode.golang.builder into chaincode compilation: setting the
+// config to "docker", "kaniko" or "buildah" must select the matching
+// Builder, the same way NewBuilder does on its own.
+func TestPlatformBuild(t *testing.T) {
+	defer viper.Set("chaincode.golang.builder", "")
+
+	const path = "github.com/hyperledger/fabric/core/chaincode/platforms/golang/testdata/src/chaincodes/noop"
+	platform := &Platform{}
+
+	inputTar, err := platform.GetDeploymentPayload(path)
+	require.NoError(t, err, "failed to build chaincode input tar from noop fixture")
+
+	var tests = []struct {
+		name         string
+		builder      string
+		bin          string
+		requiresRoot bool
+	}{
+		{name: "Docker", builder: "docker", bin: "docker"},
+		{name: "Kaniko", builder: "kaniko", requiresRoot: true},
+		{name: "Buildah", builder: "buildah", bin: "buildah"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.bin != "" {
+				if _, err := exec.LookPath(tt.bin); err != nil {
+					t.Skipf("%s not available: %s", tt.bin, err)
+				}
+			}
+			if tt.requiresRoot && os.Geteuid() != 0 {
+				t.Skip("kaniko's executor requires root privileges")
+			}
+
+			viper.Set("chaincode.golang.builder", tt.builder)
+
+			outputTar := bytes.NewBuffer(nil)
+			err := platform.Build(context.Background(), path, bytes.NewReader(inputTar), outputTar)
+			require.NoError(t, err, "Platform.Build with chaincode.golang.builder=%s failed", tt.builder)
+			assert.NotEmpty(t, outputTar.Bytes())
+		})
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package golang
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containers/storage"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+)
+
+// outputDir is the directory build.sh writes its deliverables
+// (binpackage.tar, the per-arch binpackage-<os>-<arch>.tar archives, and
+// manifest.json) to inside the build image.
+const outputDir = "/chaincode/output"
+
+// extractInputTar unpacks the chaincode input tar (the same
+// /chaincode/input/src layout the docker backend mounts) into dir, so that
+// in-process backends can hand a plain build context to their respective
+// libraries.
+func extractInputTar(inputTar io.Reader, dir string) error {
+	tr := tar.NewReader(inputTar)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, header.Name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeDockerfile materializes the build-only Dockerfile and build script
+// under buildContext so that both Kaniko and Buildah can consume them as a
+// plain filesystem build context. dockerfile is expected to be a complete,
+// self-contained Dockerfile (BuildInstructions.BuildDockerfile) - it is
+// written as-is, not appended to.
+func writeDockerfile(buildContext, dockerfile, script string) (string, error) {
+	scriptPath := filepath.Join(buildContext, "build.sh")
+	if err := os.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		return "", errors.Wrap(err, "failed to write build script")
+	}
+
+	dockerfilePath := filepath.Join(buildContext, "Dockerfile")
+	if err := os.WriteFile(dockerfilePath, []byte(dockerfile), 0644); err != nil {
+		return "", errors.Wrap(err, "failed to write Dockerfile")
+	}
+
+	return dockerfilePath, nil
+}
+
+// collectOutputDir reads every regular file rooted at outputDir out of src,
+// stripping that prefix, and merges them into collected. Called once per
+// image layer in build order, so that a file rewritten by a later layer
+// naturally overwrites the version collected from an earlier one instead of
+// appearing twice.
+func collectOutputDir(src *tar.Reader, collected map[string]archiveEntry) error {
+	prefix := strings.TrimPrefix(outputDir, "/") + "/"
+	for {
+		header, err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(header.Name, "/")
+		if header.Typeflag != tar.TypeReg || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(src)
+		if err != nil {
+			return err
+		}
+
+		relName := strings.TrimPrefix(name, prefix)
+		collected[relName] = archiveEntry{Name: relName, Mode: header.Mode, Data: data}
+	}
+}
+
+// writeCollectedTar writes collected into outputTar in a stable (sorted)
+// order.
+func writeCollectedTar(collected map[string]archiveEntry, outputTar io.Writer) error {
+	names := make([]string, 0, len(collected))
+	for name := range collected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tar.NewWriter(outputTar)
+	for _, name := range names {
+		entry := collected[name]
+		header := &tar.Header{Name: entry.Name, Mode: entry.Mode, Size: int64(len(entry.Data))}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.Data); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// writeLayersAsTar reads /chaincode/output out of every layer of image, in
+// build order, and writes only that directory's contents (not the rest of
+// the build image's filesystem) into outputTar.
+func writeLayersAsTar(image v1.Image, outputTar io.Writer) error {
+	layers, err := image.Layers()
+	if err != nil {
+		return errors.Wrap(err, "failed to read kaniko image layers")
+	}
+
+	collected := map[string]archiveEntry{}
+	for _, layer := range layers {
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return errors.Wrap(err, "failed to read kaniko layer contents")
+		}
+
+		err = collectOutputDir(tar.NewReader(rc), collected)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeCollectedTar(collected, outputTar)
+}
+
+// writeImageAsTar reads /chaincode/output out of the buildah-built image
+// identified by imageID, and writes only that directory's contents (not the
+// rest of the build image's filesystem) into outputTar.
+func writeImageAsTar(store storage.Store, imageID string, outputTar io.Writer) error {
+	data, _, err := store.ImageBigData(imageID, "layer.tar")
+	if err != nil {
+		return errors.Wrapf(err, "failed to read buildah image %s", imageID)
+	}
+
+	collected := map[string]archiveEntry{}
+	if err := collectOutputDir(tar.NewReader(bytes.NewReader(data)), collected); err != nil {
+		return err
+	}
+
+	return writeCollectedTar(collected, outputTar)
+}
@@ -0,0 +1,419 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package golang
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/chaincode/platforms/util"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"golang.org/x/mod/modfile"
+)
+
+// Platform for chaincodes written in Go
+type Platform struct {
+}
+
+// CodeDescriptor describes the location of the chaincode source that will
+// be packaged and the META-INF root that travels alongside it.
+type CodeDescriptor struct {
+	Source       string // filesystem location of the chaincode source
+	MetadataRoot string // filesystem location of the META-INF content
+	Path         string // path/import path as provided by the caller
+	Module       bool   // true when Source is rooted in a go module
+}
+
+// SourceFile is a single file that will be added to the chaincode package.
+type SourceFile struct {
+	fsPath string
+	Mode   os.FileMode
+}
+
+// Sources indexes the files that will be written into a chaincode package,
+// keyed by their path within the archive.
+type Sources map[string]*SourceFile
+
+const (
+	staticLDFlagsOpts  = `-ldflags "-linkmode external -extldflags '-static'"`
+	dynamicLDFlagsOpts = `-ldflags "-linkmode external"`
+)
+
+const defaultGoProxy = "https://proxy.golang.org"
+
+// Name returns the name of this platform.
+func (p *Platform) Name() string {
+	return pb.ChaincodeSpec_GOLANG.String()
+}
+
+// ValidatePath validates the chaincode path to satisfy the platform
+// interface. It rejects any path that carries a URL scheme and any path
+// that does not resolve under GOPATH/src.
+func (p *Platform) ValidatePath(rawPath string) error {
+	parsed, err := url.Parse(rawPath)
+	if err != nil {
+		return fmt.Errorf("invalid path: %s", err)
+	}
+	if parsed.Scheme != "" {
+		return fmt.Errorf("invalid path: %s", rawPath)
+	}
+
+	gopath, err := firstGopath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(gopath, "src", rawPath)); err != nil {
+		return fmt.Errorf("invalid path: %s", err)
+	}
+
+	return nil
+}
+
+// NormalizePath returns the module path for go-modules based chaincode so
+// that callers do not need to know whether GOPATH or modules are in play.
+// Paths that are not rooted in a module (including GOPATH-style import
+// paths) are returned unchanged.
+func (p *Platform) NormalizePath(rawPath string) (string, error) {
+	fi, err := os.Stat(rawPath)
+	if err != nil || !fi.IsDir() {
+		return rawPath, nil
+	}
+
+	cmd := exec.Command("go", "env", "GOMOD")
+	cmd.Dir = rawPath
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to probe module root of %s", rawPath)
+	}
+
+	gomod := strings.TrimSpace(string(out))
+	if gomod == "" || gomod == os.DevNull {
+		return rawPath, nil
+	}
+
+	modBytes, err := ioutil.ReadFile(gomod)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %s", gomod)
+	}
+
+	modulePath := modfile.ModulePath(modBytes)
+	if modulePath == "" {
+		return rawPath, nil
+	}
+
+	return modulePath, nil
+}
+
+// tar mode bits that are never acceptable inside a chaincode package.
+const (
+	tarHeaderModeSetuid = 04000
+	tarHeaderModeSetgid = 02000
+)
+
+// c_ISDIR is the directory mode bit, as defined in the tar package.
+const c_ISDIR = 040000
+
+// ValidateCodePackage inspects the gzipped tar produced by
+// GetDeploymentPayload and rejects anything that is not a plain file or
+// directory rooted at "src/" or "META-INF/".
+func (p *Platform) ValidateCodePackage(code []byte) error {
+	if len(code) == 0 {
+		return nil
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(code))
+	if err != nil {
+		return fmt.Errorf("failure opening codepackage gzip stream: %s", err)
+	}
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Mode&(tarHeaderModeSetuid|tarHeaderModeSetgid) != 0 {
+			return fmt.Errorf("illegal file mode detected for file %s", header.Name)
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeDir {
+			return fmt.Errorf("illegal file type detected in payload: \"%s\"", header.Name)
+		}
+
+		if strings.HasPrefix(header.Name, "/") {
+			return fmt.Errorf("illegal file detected in payload: \"%s\"", header.Name)
+		}
+
+		if !strings.HasPrefix(header.Name, "src/") && !strings.HasPrefix(header.Name, "META-INF/") {
+			return fmt.Errorf("illegal file detected in payload: \"%s\"", header.Name)
+		}
+	}
+
+	return nil
+}
+
+// findSource walks the descriptor's Source and MetadataRoot, returning the
+// set of files that make up the chaincode package, indexed by their path
+// within the archive.
+func findSource(descriptor *CodeDescriptor) (Sources, error) {
+	sources := Sources{}
+
+	err := filepath.Walk(descriptor.Source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == descriptor.MetadataRoot {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		relativeRoot := descriptor.Path
+		if descriptor.Module {
+			relativeRoot = ""
+		}
+
+		relPath, err := filepath.Rel(descriptor.Source, path)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(filepath.Join("src", relativeRoot, relPath))
+		sources[name] = &SourceFile{fsPath: path, Mode: info.Mode()}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(descriptor.MetadataRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(filepath.Dir(descriptor.MetadataRoot), path)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(relPath)
+		sources[name] = &SourceFile{fsPath: path, Mode: info.Mode()}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sources, nil
+}
+
+// reproducibleMode masks a file's mode down to a plain executable or
+// non-executable file, discarding any other permission or setuid/setgid
+// bits so that the packaged mode never depends on the umask of the
+// machine that built it.
+func reproducibleMode(mode os.FileMode) int64 {
+	if mode&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
+
+// getCodeDescriptor resolves a chaincode path, provided either as a
+// GOPATH-style import path or as a filesystem path to a go module, into a
+// CodeDescriptor that findSource can walk.
+func (p *Platform) getCodeDescriptor(path string) (*CodeDescriptor, error) {
+	if path == "" {
+		return nil, errors.New("argument path cannot be empty")
+	}
+
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		modulePath, err := p.NormalizePath(path)
+		if err != nil {
+			return nil, err
+		}
+		if modulePath != path {
+			return &CodeDescriptor{
+				Source:       path,
+				MetadataRoot: filepath.Join(path, "META-INF"),
+				Path:         modulePath,
+				Module:       true,
+			}, nil
+		}
+	}
+
+	gopath, err := firstGopath()
+	if err != nil {
+		return nil, err
+	}
+
+	source := filepath.Join(gopath, "src", path)
+	if fi, err := os.Stat(source); err != nil || !fi.IsDir() {
+		return nil, errors.Errorf("could not read directory %s", source)
+	}
+
+	return &CodeDescriptor{
+		Source:       source,
+		MetadataRoot: filepath.Join(source, "META-INF"),
+		Path:         path,
+	}, nil
+}
+
+// archiveEntry is a single named file destined for a reproducible chaincode
+// package tar, already loaded into memory.
+type archiveEntry struct {
+	Name string
+	Mode int64
+	Data []byte
+}
+
+// buildReproducibleTar writes entries into a gzipped tar that is
+// byte-for-byte reproducible across machines: entries are written in
+// lexicographic order, all ownership and timestamp metadata is stripped,
+// and USTAR headers are preferred over PAX extended headers for
+// compactness. An entry whose name does not fit USTAR's prefix/name split
+// (e.g. a deeply nested vendored path) falls back to a PAX header for that
+// entry alone rather than failing the whole package build; the fallback is
+// just as reproducible since every other field is still zeroed.
+func buildReproducibleTar(entries []archiveEntry) ([]byte, error) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	payload := bytes.NewBuffer(nil)
+	gw, err := gzip.NewWriterLevel(payload, gzip.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	gw.Header = gzip.Header{}
+	tw := tar.NewWriter(gw)
+
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:       entry.Name,
+			Mode:       entry.Mode,
+			Size:       int64(len(entry.Data)),
+			Uid:        0,
+			Gid:        0,
+			Uname:      "",
+			Gname:      "",
+			ModTime:    time.Time{},
+			AccessTime: time.Time{},
+			ChangeTime: time.Time{},
+			Format:     tar.FormatUSTAR,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			header.Format = tar.FormatPAX
+			if err := tw.WriteHeader(header); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := tw.Write(entry.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	return payload.Bytes(), nil
+}
+
+// GetDeploymentPayload creates a gzipped tar stream of the chaincode source
+// and its META-INF content for the given chaincode path.
+func (p *Platform) GetDeploymentPayload(path string) ([]byte, error) {
+	descriptor, err := p.getCodeDescriptor(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err := findSource(descriptor)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]archiveEntry, 0, len(sources))
+	for name, source := range sources {
+		contents, err := ioutil.ReadFile(source.fsPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", source.fsPath)
+		}
+		entries = append(entries, archiveEntry{Name: name, Mode: reproducibleMode(source.Mode), Data: contents})
+	}
+
+	return buildReproducibleTar(entries)
+}
+
+// GenerateDockerfile returns the Dockerfile used to assemble the chaincode
+// runtime image from the binpackage.tar produced by the configured build
+// backend.
+func (p *Platform) GenerateDockerfile() (string, error) {
+	instructions, err := p.buildInstructions("")
+	if err != nil {
+		return "", err
+	}
+	return instructions.Dockerfile, nil
+}
+
+// getLDFlagsOpts returns the ldflags to pass to `go build`, favoring a
+// statically linked binary unless dynamic linking was explicitly requested.
+func getLDFlagsOpts() string {
+	if viper.GetBool("chaincode.golang.dynamicLink") {
+		return dynamicLDFlagsOpts
+	}
+	return staticLDFlagsOpts
+}
+
+// DockerBuildOptions returns the options used to build the chaincode
+// binary inside the (possibly daemonless) build backend selected by
+// `chaincode.golang.builder`.
+func (p *Platform) DockerBuildOptions(path string) (util.DockerBuildOptions, error) {
+	instructions, err := p.buildInstructions(path)
+	if err != nil {
+		return util.DockerBuildOptions{}, err
+	}
+	return util.DockerBuildOptions{Cmd: instructions.Script}, nil
+}
+
+func firstGopath() (string, error) {
+	output, err := exec.Command("go", "env", "GOPATH").Output()
+	if err != nil {
+		return "", err
+	}
+
+	pathElements := filepath.SplitList(strings.TrimSpace(string(output)))
+	if len(pathElements) == 0 {
+		return "", fmt.Errorf("GOPATH is not set")
+	}
+
+	return pathElements[0], nil
+}
@@ -10,15 +10,19 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	pb "github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric/core/chaincode/platforms/util"
@@ -232,6 +236,59 @@ func TestDeploymentPayload(t *testing.T) {
 	assert.Equal(t, true, foundIndexArtifact, "should have found statedb index artifact in noop META-INF directory")
 }
 
+// TestReproducibleDeploymentPayload builds the same chaincode path twice
+// under different umask, working directory, and clock settings and checks
+// that the resulting tar hashes identically, so operators can attest to a
+// chaincode package hash independently of who built it. The clock reaches
+// the package through the source files' modification times, so each build
+// gives the fixture's chaincode.go a different mtime.
+func TestReproducibleDeploymentPayload(t *testing.T) {
+	const path = "github.com/hyperledger/fabric/core/chaincode/platforms/golang/testdata/src/chaincodes/noop"
+
+	gopath, err := getGopath()
+	require.NoError(t, err)
+	fixture := filepath.Join(gopath, "src", path, "chaincode.go")
+	info, err := os.Stat(fixture)
+	require.NoError(t, err)
+	defer os.Chtimes(fixture, info.ModTime(), info.ModTime())
+
+	build := func(umaskValue int, wd string, mtime time.Time) []byte {
+		require.NoError(t, os.Chtimes(fixture, mtime, mtime))
+
+		oldUmask := syscall.Umask(umaskValue)
+		defer syscall.Umask(oldUmask)
+
+		oldWd, err := os.Getwd()
+		require.NoError(t, err)
+		require.NoError(t, os.Chdir(wd))
+		defer os.Chdir(oldWd)
+
+		platform := &Platform{}
+		payload, err := platform.GetDeploymentPayload(path)
+		require.NoError(t, err, "failed to build deployment payload")
+		return payload
+	}
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	first := build(0022, wd, time.Unix(0, 0))
+	second := build(0077, os.TempDir(), time.Now())
+
+	firstSum := sha256.Sum256(first)
+	secondSum := sha256.Sum256(second)
+	assert.Equal(t, firstSum, secondSum, "deployment payload should be reproducible across umask, working directory, and clock")
+}
+
+// TestBuildReproducibleTarLongName covers an entry name too long for
+// USTAR's prefix/name split (as real vendored go module paths can produce):
+// it must fall back to a PAX header instead of failing the build.
+func TestBuildReproducibleTarLongName(t *testing.T) {
+	longName := "src/" + strings.Repeat("a", 200) + "/main.go"
+	_, err := buildReproducibleTar([]archiveEntry{{Name: longName, Mode: 0644, Data: []byte("package main")}})
+	assert.NoError(t, err, "an overlong entry name should fall back to a PAX header rather than failing the build")
+}
+
 func updateGopath(t *testing.T, path string) func() {
 	initialGopath, set := os.LookupEnv("GOPATH")
 
@@ -311,28 +368,272 @@ func TestGetLDFlagsOpts(t *testing.T) {
 	}
 }
 
-func TestDockerBuildOptions(t *testing.T) {
-	platform := &Platform{}
-
-	opts, err := platform.DockerBuildOptions("the-path")
-	assert.NoError(t, err, "unexpected error from DockerBuildOptions")
-
-	expectedOpts := util.DockerBuildOptions{
-		Cmd: `
+// dockerBuildOptionsScript renders the expected single-platform build
+// script for the host's GOOS/GOARCH, mirroring the default
+// chaincode.golang.platforms behavior. exports is the block of
+// `    export KEY="VALUE"` lines moduleBuildEnv produces for the module
+// branch.
+func dockerBuildOptionsScript(goproxy, exports string) string {
+	outDir := fmt.Sprintf("/chaincode/output/binpackage-%s-%s", runtime.GOOS, runtime.GOARCH)
+	return fmt.Sprintf(`
 set -e
 if [ -f "/chaincode/input/src/go.mod" ] && [ -d "/chaincode/input/src/vendor" ]; then
     cd /chaincode/input/src
-    GO111MODULE=on go build -v -mod=vendor -ldflags "-linkmode external -extldflags '-static'" -o /chaincode/output/chaincode the-path
+    MOD_FLAGS="-mod=vendor"
 elif [ -f "/chaincode/input/src/go.mod" ]; then
+    if [ "%s" = "off" ]; then
+        echo "chaincode.golang.goproxy=off requires a vendor directory" >&2
+        exit 1
+    fi
     cd /chaincode/input/src
-    GO111MODULE=on GOPROXY=https://proxy.golang.org go build -v -mod=readonly -ldflags "-linkmode external -extldflags '-static'" -o /chaincode/output/chaincode the-path
+    MOD_FLAGS="-mod=readonly"
+%s
 else
-    GOPATH=/chaincode/input:$GOPATH go build -v -ldflags "-linkmode external -extldflags '-static'" -o /chaincode/output/chaincode the-path
+    MOD_FLAGS=""
+    export GOPATH=/chaincode/input:$GOPATH
 fi
+mkdir -p /chaincode/output
+mkdir -p %s
+GO111MODULE=on GOOS=%s GOARCH=%s CGO_ENABLED=0 go build -v $MOD_FLAGS -ldflags "-linkmode external -extldflags '-static'" -o %s/chaincode the-path
+tar -C %s -cf %s.tar chaincode
+cat > /chaincode/output/Dockerfile-%s-%s <<'EOF'
+FROM %s
+ADD binpackage.tar /usr/local/bin
+EOF
+cat > /chaincode/output/manifest.json <<'EOF'
+{
+  "%s/%s": "binpackage-%s-%s.tar"
+}
+EOF
+cp %s.tar /chaincode/output/binpackage.tar
 echo Done!
-`,
+`, goproxy, exports, outDir, runtime.GOOS, runtime.GOARCH, outDir, outDir, outDir,
+		runtime.GOOS, runtime.GOARCH, "",
+		runtime.GOOS, runtime.GOARCH, runtime.GOOS, runtime.GOARCH, outDir)
+}
+
+// TestDockerBuildOptions covers the chaincode.golang.goproxy,
+// chaincode.golang.gosumdb, chaincode.golang.goprivate,
+// chaincode.golang.gonosumcheck and chaincode.golang.goflags viper keys,
+// individually and combined, plus rejection of shell metacharacters in any
+// of them.
+func TestDockerBuildOptions(t *testing.T) {
+	resetGoEnv := func() {
+		viper.Set("chaincode.golang.goproxy", "")
+		viper.Set("chaincode.golang.gosumdb", "")
+		viper.Set("chaincode.golang.goprivate", "")
+		viper.Set("chaincode.golang.gonosumcheck", "")
+		viper.Set("chaincode.golang.goflags", "")
+		viper.Set("chaincode.golang.platforms", "")
+		// dockerBuildOptionsScript hardcodes an empty runtime image for the
+		// per-target Dockerfile it now expects in the generated script, so
+		// this must be reset too - otherwise a prior test that sets
+		// chaincode.golang.runtime (TestGenerateDockerFile does) leaks into
+		// this one.
+		viper.Set("chaincode.golang.runtime", "")
+	}
+	defer resetGoEnv()
+
+	var tests = []struct {
+		name          string
+		goproxy       string
+		gosumdb       string
+		goprivate     string
+		gonosumcheck  string
+		goflags       string
+		expectProxy   string
+		expectExports string
+		succ          bool
+	}{
+		{
+			name:          "Defaults",
+			expectProxy:   "https://proxy.golang.org",
+			expectExports: `    export GOPROXY="https://proxy.golang.org"`,
+			succ:          true,
+		},
+		{
+			name:          "CustomProxy",
+			goproxy:       "https://goproxy.example.com",
+			expectProxy:   "https://goproxy.example.com",
+			expectExports: `    export GOPROXY="https://goproxy.example.com"`,
+			succ:          true,
+		},
+		{
+			name:          "ProxyOff",
+			goproxy:       "off",
+			expectProxy:   "off",
+			expectExports: `    export GOPROXY="off"`,
+			succ:          true,
+		},
+		{
+			name:        "Gosumdb",
+			gosumdb:     "off",
+			expectProxy: "https://proxy.golang.org",
+			expectExports: "    export GOPROXY=\"https://proxy.golang.org\"\n" +
+				"    export GOSUMDB=\"off\"",
+			succ: true,
+		},
+		{
+			name:        "Goprivate",
+			goprivate:   "example.com/internal/*",
+			expectProxy: "https://proxy.golang.org",
+			expectExports: "    export GOPROXY=\"https://proxy.golang.org\"\n" +
+				"    export GOPRIVATE=\"example.com/internal/*\"",
+			succ: true,
+		},
+		{
+			name:         "Gonosumcheck",
+			gonosumcheck: "1",
+			expectProxy:  "https://proxy.golang.org",
+			expectExports: "    export GOPROXY=\"https://proxy.golang.org\"\n" +
+				"    export GONOSUMCHECK=\"1\"",
+			succ: true,
+		},
+		{
+			name:        "Goflags",
+			goflags:     "-mod=mod",
+			expectProxy: "https://proxy.golang.org",
+			expectExports: "    export GOPROXY=\"https://proxy.golang.org\"\n" +
+				"    export GOFLAGS=\"-mod=mod\"",
+			succ: true,
+		},
+		{
+			// Regression test for a word-splitting bug: GOFLAGS is
+			// documented as a space-separated list of flags, and the
+			// generated script used to interpolate it unquoted into a
+			// single `env $PROXY_ENV ...` invocation, so the second flag
+			// was treated as the command `env` should run instead of part
+			// of GOFLAGS. Quoting it in its own export line preserves the
+			// space.
+			name:        "GoflagsMultipleFlags",
+			goflags:     "-mod=mod -trimpath",
+			expectProxy: "https://proxy.golang.org",
+			expectExports: "    export GOPROXY=\"https://proxy.golang.org\"\n" +
+				"    export GOFLAGS=\"-mod=mod -trimpath\"",
+			succ: true,
+		},
+		{
+			name:         "AllCombined",
+			goproxy:      "https://goproxy.example.com",
+			gosumdb:      "sum.example.com",
+			goprivate:    "example.com/internal/*",
+			gonosumcheck: "1",
+			goflags:      "-mod=mod",
+			expectProxy:  "https://goproxy.example.com",
+			expectExports: "    export GOPROXY=\"https://goproxy.example.com\"\n" +
+				"    export GOSUMDB=\"sum.example.com\"\n" +
+				"    export GOPRIVATE=\"example.com/internal/*\"\n" +
+				"    export GONOSUMCHECK=\"1\"\n" +
+				"    export GOFLAGS=\"-mod=mod\"",
+			succ: true,
+		},
+		{
+			name:    "RejectsShellMetacharacters",
+			goproxy: "https://proxy.golang.org; rm -rf /",
+			succ:    false,
+		},
+		{
+			name:          "GoproxyFallbackList",
+			goproxy:       "https://proxy.example.com|https://proxy.golang.org",
+			expectProxy:   "https://proxy.example.com|https://proxy.golang.org",
+			expectExports: `    export GOPROXY="https://proxy.example.com|https://proxy.golang.org"`,
+			succ:          true,
+		},
+		{
+			name:      "GoprivateRejectsPipe",
+			goprivate: "example.com/internal|example.com/other",
+			succ:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetGoEnv()
+			viper.Set("chaincode.golang.goproxy", tt.goproxy)
+			viper.Set("chaincode.golang.gosumdb", tt.gosumdb)
+			viper.Set("chaincode.golang.goprivate", tt.goprivate)
+			viper.Set("chaincode.golang.gonosumcheck", tt.gonosumcheck)
+			viper.Set("chaincode.golang.goflags", tt.goflags)
+
+			platform := &Platform{}
+			opts, err := platform.DockerBuildOptions("the-path")
+			if !tt.succ {
+				assert.Error(t, err, "expected DockerBuildOptions to reject %+v", tt)
+				return
+			}
+			require.NoError(t, err, "unexpected error from DockerBuildOptions")
+
+			expectedOpts := util.DockerBuildOptions{
+				Cmd: dockerBuildOptionsScript(tt.expectProxy, tt.expectExports),
+			}
+			assert.Equal(t, expectedOpts, opts)
+		})
+	}
+}
+
+// TestDockerBuildOptionsMultiPlatform covers chaincode.golang.platforms:
+// one go build invocation (and one binpackage-<os>-<arch>.tar) per
+// requested target, plus a manifest.json mapping each target to its
+// archive.
+func TestDockerBuildOptionsMultiPlatform(t *testing.T) {
+	defer viper.Set("chaincode.golang.platforms", "")
+
+	viper.Set("chaincode.golang.platforms", "linux/amd64,linux/arm64,linux/s390x")
+
+	platform := &Platform{}
+	opts, err := platform.DockerBuildOptions("the-path")
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, strings.Count(opts.Cmd, "go build"), "expected one go build invocation per requested platform")
+	for _, target := range []string{"linux-amd64", "linux-arm64", "linux-s390x"} {
+		assert.Contains(t, opts.Cmd, fmt.Sprintf("binpackage-%s", target))
+	}
+	assert.Contains(t, opts.Cmd, `"linux/amd64": "binpackage-linux-amd64.tar"`)
+	assert.Contains(t, opts.Cmd, `"linux/arm64": "binpackage-linux-arm64.tar"`)
+	assert.Contains(t, opts.Cmd, `"linux/s390x": "binpackage-linux-s390x.tar"`)
+	assert.Contains(t, opts.Cmd, "cp /chaincode/output/binpackage-linux-amd64.tar /chaincode/output/binpackage.tar",
+		"the first requested platform's archive must be copied to the canonical binpackage.tar that GenerateDockerfile's ADD line expects")
+
+	for _, target := range []string{"linux-amd64", "linux-arm64", "linux-s390x"} {
+		assert.Contains(t, opts.Cmd, fmt.Sprintf("cat > /chaincode/output/Dockerfile-%s <<'EOF'", target),
+			"every requested platform, not just the first, must get its own runtime Dockerfile in the build output")
 	}
-	assert.Equal(t, expectedOpts, opts)
+}
+
+func TestDockerBuildOptionsInvalidPlatform(t *testing.T) {
+	defer viper.Set("chaincode.golang.platforms", "")
+
+	viper.Set("chaincode.golang.platforms", "not-a-platform")
+
+	platform := &Platform{}
+	_, err := platform.DockerBuildOptions("the-path")
+	assert.Error(t, err)
+}
+
+// TestGenerateDockerfileForPlatform covers the per-arch runtime image
+// override (chaincode.golang.runtime.<os>-<arch>).
+func TestGenerateDockerfileForPlatform(t *testing.T) {
+	defer func() {
+		viper.Set("chaincode.golang.runtime", "")
+		viper.Set("chaincode.golang.runtime.linux-arm64", "")
+	}()
+
+	viper.Set("chaincode.golang.runtime", "buildimage")
+	platform := &Platform{}
+
+	dockerfile, err := platform.GenerateDockerfileForPlatform(TargetPlatform{OS: "linux", Arch: "amd64"})
+	require.NoError(t, err)
+	assert.Equal(t, "FROM buildimage\nADD binpackage.tar /usr/local/bin", dockerfile)
+
+	viper.Set("chaincode.golang.runtime.linux-arm64", "buildimage-arm64")
+	dockerfile, err = platform.GenerateDockerfileForPlatform(TargetPlatform{OS: "linux", Arch: "arm64"})
+	require.NoError(t, err)
+	assert.Equal(t, "FROM buildimage-arm64\nADD binpackage.tar /usr/local/bin", dockerfile)
+
+	// an arch without an override still falls back to the platform-wide runtime
+	dockerfile, err = platform.GenerateDockerfileForPlatform(TargetPlatform{OS: "linux", Arch: "amd64"})
+	require.NoError(t, err)
+	assert.Equal(t, "FROM buildimage\nADD binpackage.tar /usr/local/bin", dockerfile)
 }
 
 func TestMain(m *testing.M) {
@@ -0,0 +1,368 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package golang
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// provenanceFileName is the path, inside the chaincode package tar, under
+// which the SLSA-style attestation produced by
+// GetDeploymentPayloadWithProvenance travels.
+const provenanceFileName = "META-INF/provenance/slsa.json"
+
+// Provenance is a signed attestation of what produced a chaincode
+// CodePackage: the resolved module, the source that went into it, the
+// toolchain and flags used to build it, and the hash of the package as
+// built without the attestation. The package that carries the attestation
+// under META-INF/provenance/slsa.json therefore hashes differently from
+// CodePackageHash. It lets an operator prove which sources produced a given
+// chaincode hash.
+type Provenance struct {
+	ModulePath      string            `json:"module_path"`
+	ModuleVersion   string            `json:"module_version,omitempty"`
+	GoSum           string            `json:"go_sum,omitempty"`
+	SourceFiles     map[string]string `json:"source_files"`
+	GoVersion       string            `json:"go_version"`
+	BuildFlags      []string          `json:"build_flags"`
+	CodePackageHash string            `json:"codepackage_sha256"`
+	Signature       []byte            `json:"signature,omitempty"`
+}
+
+// signingDigest is the sha256 of the canonical JSON encoding of the
+// provenance with Signature cleared, i.e. what Signature actually signs.
+func (p *Provenance) signingDigest() []byte {
+	unsigned := *p
+	unsigned.Signature = nil
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		// Provenance only holds strings, a string slice, a string map, and a
+		// byte slice, so Marshal cannot fail.
+		panic(err)
+	}
+	digest := sha256.Sum256(data)
+	return digest[:]
+}
+
+// GetDeploymentPayloadWithProvenance is GetDeploymentPayload plus a signed
+// Provenance attestation describing exactly what went into the returned
+// tar. The attestation is also embedded in the tar under
+// META-INF/provenance/slsa.json so that it travels with the package.
+func (p *Platform) GetDeploymentPayloadWithProvenance(path string) ([]byte, *Provenance, error) {
+	descriptor, err := p.getCodeDescriptor(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sources, err := findSource(descriptor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, exists := sources[provenanceFileName]; exists {
+		return nil, nil, errors.Errorf("chaincode source already contains %s, which is reserved for the provenance attestation", provenanceFileName)
+	}
+
+	goSumName := rootGoSumName(descriptor)
+
+	entries := make([]archiveEntry, 0, len(sources))
+	sourceHashes := make(map[string]string, len(sources))
+	var goSum string
+	for name, source := range sources {
+		contents, err := ioutil.ReadFile(source.fsPath)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to read %s", source.fsPath)
+		}
+
+		entries = append(entries, archiveEntry{Name: name, Mode: reproducibleMode(source.Mode), Data: contents})
+
+		sum := sha256.Sum256(contents)
+		sourceHashes[name] = hex.EncodeToString(sum[:])
+
+		if name == goSumName {
+			goSum = string(contents)
+		}
+	}
+
+	payload, err := buildReproducibleTar(entries)
+	if err != nil {
+		return nil, nil, err
+	}
+	payloadHash := sha256.Sum256(payload)
+
+	goVersion, err := goToolchainVersion()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	moduleVersion := resolveModuleVersion(descriptor)
+
+	modFlag := ""
+	if descriptor.Module {
+		modFlag = "-mod=readonly"
+		if _, err := os.Stat(filepath.Join(descriptor.Source, "vendor")); err == nil {
+			modFlag = "-mod=vendor"
+		}
+	}
+
+	buildFlags := []string{getLDFlagsOpts()}
+	if modFlag != "" {
+		buildFlags = append(buildFlags, modFlag)
+	}
+
+	provenance := &Provenance{
+		ModulePath:      descriptor.Path,
+		ModuleVersion:   moduleVersion,
+		GoSum:           goSum,
+		SourceFiles:     sourceHashes,
+		GoVersion:       goVersion,
+		BuildFlags:      buildFlags,
+		CodePackageHash: hex.EncodeToString(payloadHash[:]),
+	}
+
+	if err := signProvenance(provenance); err != nil {
+		return nil, nil, err
+	}
+
+	provenanceJSON, err := json.MarshalIndent(provenance, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries = append(entries, archiveEntry{Name: provenanceFileName, Mode: 0644, Data: provenanceJSON})
+
+	final, err := buildReproducibleTar(entries)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return final, provenance, nil
+}
+
+// rootGoSumName returns the archive path, computed the same way findSource
+// builds it, of the go.sum at the root of the chaincode's source tree. It is
+// used to pick the provenance's recorded GoSum out of a deterministic,
+// known location rather than pattern-matching against every file in the
+// package.
+func rootGoSumName(descriptor *CodeDescriptor) string {
+	relativeRoot := descriptor.Path
+	if descriptor.Module {
+		relativeRoot = ""
+	}
+	return filepath.ToSlash(filepath.Join("src", relativeRoot, "go.sum"))
+}
+
+// signProvenance signs the provenance's signingDigest with the ed25519 key
+// at chaincode.golang.provenance.keyfile (a PEM-encoded PKCS#8 private
+// key). It is a no-op, leaving the attestation unsigned, when that key is
+// not configured.
+func signProvenance(p *Provenance) error {
+	keyfile := viper.GetString("chaincode.golang.provenance.keyfile")
+	if keyfile == "" {
+		return nil
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyfile)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read chaincode.golang.provenance.keyfile %s", keyfile)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return errors.Errorf("no PEM block found in %s", keyfile)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse provenance signing key %s", keyfile)
+	}
+
+	signer, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return errors.Errorf("unsupported provenance signing key type %T: only ed25519 is supported", key)
+	}
+
+	p.Signature = ed25519.Sign(signer, p.signingDigest())
+	return nil
+}
+
+// goToolchainVersion returns the `go version` string of the toolchain
+// available on PATH, recorded in the provenance so that a verifier knows
+// which compiler produced the package.
+func goToolchainVersion() (string, error) {
+	out, err := exec.Command("go", "version").Output()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to determine go toolchain version")
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// resolveModuleVersion best-effort resolves the VCS revision of
+// descriptor.Source: the closest available analogue to a "module version"
+// for chaincode built directly out of a source checkout rather than fetched
+// as a versioned dependency. Only module-mode chaincode (descriptor.Module)
+// has a real go.mod to version; GOPATH-style chaincode has no module at
+// all, so ModuleVersion is left empty for it. A checkout that isn't under
+// git, or has no tags, is not an error - the attestation is still valid
+// without it, just less precise.
+//
+// The dirty check is scoped to descriptor.Source alone rather than using
+// `git describe --dirty`, which reports on the whole working tree: a
+// chaincode module living in a subdirectory of a larger checkout (the
+// fabric repo itself, during development, is one) must not have its
+// version marked dirty by an unrelated uncommitted change elsewhere in
+// that checkout.
+func resolveModuleVersion(descriptor *CodeDescriptor) string {
+	if !descriptor.Module {
+		return ""
+	}
+
+	out, err := exec.Command("git", "-C", descriptor.Source, "describe", "--tags", "--always").Output()
+	if err != nil {
+		return ""
+	}
+	version := strings.TrimSpace(string(out))
+
+	status, err := exec.Command("git", "-C", descriptor.Source, "status", "--porcelain", "--", ".").Output()
+	if err == nil && len(strings.TrimSpace(string(status))) > 0 {
+		version += "-dirty"
+	}
+
+	return version
+}
+
+// VerifyProvenance recomputes the source file hashes and the codepackage
+// hash from codePackage, checks them against the embedded Provenance, and
+// checks that the Provenance's signature validates against one of
+// trustedKeys.
+func (p *Platform) VerifyProvenance(codePackage []byte, trustedKeys []crypto.PublicKey) error {
+	entries, provenance, err := extractProvenance(codePackage)
+	if err != nil {
+		return err
+	}
+
+	if len(provenance.Signature) == 0 {
+		return errors.New("provenance is not signed")
+	}
+
+	digest := provenance.signingDigest()
+	verified := false
+	for _, trusted := range trustedKeys {
+		pub, ok := trusted.(ed25519.PublicKey)
+		if !ok {
+			continue
+		}
+		if ed25519.Verify(pub, digest, provenance.Signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return errors.New("provenance signature does not match any trusted key")
+	}
+
+	for name, entry := range entries {
+		if name == provenanceFileName {
+			continue
+		}
+		expected, ok := provenance.SourceFiles[name]
+		if !ok {
+			return errors.Errorf("file %s is present in the package but not covered by provenance", name)
+		}
+		sum := sha256.Sum256(entry.Data)
+		if hex.EncodeToString(sum[:]) != expected {
+			return errors.Errorf("file %s does not match the hash recorded in provenance", name)
+		}
+	}
+	for name := range provenance.SourceFiles {
+		if _, ok := entries[name]; !ok {
+			return errors.Errorf("file %s is recorded in provenance but missing from the package", name)
+		}
+	}
+
+	baseEntries := make([]archiveEntry, 0, len(entries))
+	for name, entry := range entries {
+		if name == provenanceFileName {
+			continue
+		}
+		baseEntries = append(baseEntries, archiveEntry{Name: name, Mode: entry.Mode, Data: entry.Data})
+	}
+
+	basePayload, err := buildReproducibleTar(baseEntries)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(basePayload)
+	if hex.EncodeToString(sum[:]) != provenance.CodePackageHash {
+		return errors.New("codepackage hash does not match the hash recorded in provenance")
+	}
+
+	return nil
+}
+
+// extractProvenance reads every entry out of the gzipped tar codePackage
+// and parses its embedded slsa.json attestation.
+func extractProvenance(codePackage []byte) (map[string]archiveEntry, *Provenance, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(codePackage))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open codepackage gzip stream")
+	}
+
+	entries := map[string]archiveEntry{}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries[header.Name] = archiveEntry{Name: header.Name, Mode: header.Mode, Data: data}
+	}
+
+	// The tar reader stops at the end-of-archive marker without reading the
+	// gzip trailer; draining the stream makes gzip verify its checksum.
+	if _, err := io.Copy(ioutil.Discard, gr); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read codepackage gzip stream")
+	}
+
+	provenanceEntry, ok := entries[provenanceFileName]
+	if !ok {
+		return nil, nil, errors.Errorf("%s not found in codepackage", provenanceFileName)
+	}
+
+	var provenance Provenance
+	if err := json.Unmarshal(provenanceEntry.Data, &provenance); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse provenance attestation")
+	}
+
+	return entries, &provenance, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package golang
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generateProvenanceKeyfile(t *testing.T) (string, ed25519.PublicKey) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "provenance-key")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	keyfile := filepath.Join(dir, "provenance.key")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	require.NoError(t, ioutil.WriteFile(keyfile, pemBytes, 0600))
+
+	return keyfile, pub
+}
+
+func TestGetDeploymentPayloadWithProvenance(t *testing.T) {
+	defer viper.Set("chaincode.golang.provenance.keyfile", "")
+
+	keyfile, pub := generateProvenanceKeyfile(t)
+	viper.Set("chaincode.golang.provenance.keyfile", keyfile)
+
+	platform := &Platform{}
+	payload, provenance, err := platform.GetDeploymentPayloadWithProvenance(
+		"github.com/hyperledger/fabric/core/chaincode/platforms/golang/testdata/src/chaincodes/noop",
+	)
+	require.NoError(t, err)
+	assert.NotEmpty(t, payload)
+	assert.NotEmpty(t, provenance.Signature)
+	assert.NotEmpty(t, provenance.GoVersion)
+	assert.NotEmpty(t, provenance.CodePackageHash)
+	assert.Contains(t, provenance.SourceFiles, "src/chaincodes/noop/chaincode.go")
+
+	entries, extracted, err := extractProvenance(payload)
+	require.NoError(t, err)
+	assert.Contains(t, entries, provenanceFileName)
+	assert.Equal(t, provenance.CodePackageHash, extracted.CodePackageHash)
+
+	err = platform.VerifyProvenance(payload, []crypto.PublicKey{pub})
+	assert.NoError(t, err, "provenance should verify against the signing key")
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	err = platform.VerifyProvenance(payload, []crypto.PublicKey{otherPub})
+	assert.Error(t, err, "provenance should not verify against an unrelated key")
+}
+
+func TestVerifyProvenanceCorruptedPackage(t *testing.T) {
+	defer viper.Set("chaincode.golang.provenance.keyfile", "")
+
+	keyfile, pub := generateProvenanceKeyfile(t)
+	viper.Set("chaincode.golang.provenance.keyfile", keyfile)
+
+	platform := &Platform{}
+	payload, _, err := platform.GetDeploymentPayloadWithProvenance(
+		"github.com/hyperledger/fabric/core/chaincode/platforms/golang/testdata/src/chaincodes/noop",
+	)
+	require.NoError(t, err)
+
+	tampered := append([]byte(nil), payload...)
+	tampered[len(tampered)-1] ^= 0xFF
+
+	err = platform.VerifyProvenance(tampered, []crypto.PublicKey{pub})
+	assert.Error(t, err, "provenance verification should fail for a corrupted package")
+}
+
+func TestGetDeploymentPayloadWithProvenanceReservedPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenance-reserved")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/reserved\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "chaincode.go"), []byte("package main\n"), 0644))
+
+	metaDir := filepath.Join(dir, "META-INF", "provenance")
+	require.NoError(t, os.MkdirAll(metaDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(metaDir, "slsa.json"), []byte("{}"), 0644))
+
+	platform := &Platform{}
+	_, _, err = platform.GetDeploymentPayloadWithProvenance(dir)
+	require.Error(t, err, "a pre-existing META-INF/provenance/slsa.json must not be silently overwritten")
+}
+
+func TestGetDeploymentPayloadWithProvenanceUnsigned(t *testing.T) {
+	viper.Set("chaincode.golang.provenance.keyfile", "")
+
+	platform := &Platform{}
+	_, provenance, err := platform.GetDeploymentPayloadWithProvenance(
+		"github.com/hyperledger/fabric/core/chaincode/platforms/golang/testdata/src/chaincodes/noop",
+	)
+	require.NoError(t, err)
+	assert.Empty(t, provenance.Signature, "provenance should be unsigned when no keyfile is configured")
+}
+
+// TestGetDeploymentPayloadWithProvenanceModuleVersion covers ModuleVersion:
+// recorded as the chaincode source's VCS revision for a module-mode build,
+// left empty for a GOPATH-style build, which has no go.mod to version.
+func TestGetDeploymentPayloadWithProvenanceModuleVersion(t *testing.T) {
+	platform := &Platform{}
+
+	_, gopathProvenance, err := platform.GetDeploymentPayloadWithProvenance(
+		"github.com/hyperledger/fabric/core/chaincode/platforms/golang/testdata/src/chaincodes/noop",
+	)
+	require.NoError(t, err)
+	assert.Empty(t, gopathProvenance.ModuleVersion, "a GOPATH-style chaincode has no module to version")
+
+	_, moduleProvenance, err := platform.GetDeploymentPayloadWithProvenance("testdata/ccmodule")
+	require.NoError(t, err)
+	assert.NotEmpty(t, moduleProvenance.ModuleVersion, "a module-mode chaincode built from this git checkout should resolve a VCS revision")
+}